@@ -1,18 +1,27 @@
 package main
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	lru "github.com/hashicorp/golang-lru/v2"
 	goose "github.com/pressly/goose/v3"
 )
 
@@ -37,9 +46,15 @@ func main() {
 		panic(err)
 	}
 
+	notifier := newChangeNotifier()
+	go purgeTombstones(db, time.Hour)
+
 	http.HandleFunc("/list", listHandler(db))
-	http.HandleFunc("/insert", insertHandler(db))
-	http.HandleFunc("/clear", clearHandler(db))
+	http.HandleFunc("/sync", syncHandler(db, notifier))
+	http.HandleFunc("/insert", insertHandler(db, notifier))
+	http.HandleFunc("/delete", deleteHandler(db, notifier))
+	http.HandleFunc("/clear", clearHandler(db, notifier))
+	http.HandleFunc("/metrics", metricsHandler())
 
 	if err := http.ListenAndServe("127.0.0.1:8000", nil); err != nil {
 		panic(err)
@@ -84,10 +99,9 @@ func listHandler(db *sql.DB) func(w http.ResponseWriter, r *http.Request) {
 		//       Also the "updated after" timestamp will be set to this timestamp, this has the effect that only items that have been updated
 		//       after this timestamp will be returned.
 		//
-		//  Notice that this implementation is not optimal, as the client can modify next page and sync tokens.
-		//  There are various ways to mitigate this, for example by encrypting the tokens, using HMAC or storing the tokens
-		//  in a cache or database. The implementation here is just a simple example.
-		//  Choose the best approach for your use case.
+		//  Both token types are encrypted with AES-256-GCM before being handed to the client (see encryptToken/decryptToken),
+		//  so the client can no longer read or tamper with their contents. Set TOKEN_ENCRYPTION_KEY to enable this; it
+		//  falls back to an unsigned wire format when unset, which is only intended for local development.
 
 		tableInPointOfTime := time.Now().UTC()
 		var onlyNewerThan time.Time
@@ -138,71 +152,415 @@ func listHandler(db *sql.DB) func(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		var rows *sql.Rows
-		var err error
-		if onlyNewerThan.IsZero() {
-			rows, err = db.Query(
-				"SELECT * FROM users FOR SYSTEM_TIME AS OF TIMESTAMP ? ORDER BY name LIMIT ? OFFSET ?",
-				tableInPointOfTime.Format(time.DateTime),
-				maxResults,
-				offset,
-			)
-		} else {
-			rows, err = db.Query(
-				"SELECT * FROM users FOR SYSTEM_TIME AS OF TIMESTAMP ? WHERE updated_at > ? ORDER BY name LIMIT ? OFFSET ?",
-				tableInPointOfTime.Format(time.DateTime),
-				onlyNewerThan,
-				maxResults,
-				offset,
-			)
+		// FOR SYSTEM_TIME AS OF TIMESTAMP is deterministic for a fixed timestamp, so truncating
+		// tableInPointOfTime to listCacheBucket turns popular "first page" requests arriving
+		// within the same window into cache hits instead of re-running the query.
+		tableInPointOfTime = tableInPointOfTime.Truncate(listCacheBucket)
+		cacheKey := listCacheKey(tableInPointOfTime, onlyNewerThan, offset, maxResults)
+		etag := listETag(tableInPointOfTime, onlyNewerThan, offset, maxResults)
+
+		// LRU eviction and listCacheGeneration bumps cover a popular key being pushed out or
+		// invalidated by a write, but neither catches a key that just sits idle: without this
+		// check a hit could keep serving a body whose baked-in token is already past validUntil,
+		// handing the client a token that's dead on arrival.
+		if entry, ok := listCache.Get(cacheKey); ok && entry.validUntil.After(time.Now()) {
+			listCacheHits.Add(1)
+			writeListResponse(w, r, etag, entry.lastModified, entry.validUntil, entry.body)
+			return
 		}
+		listCacheMisses.Add(1)
+
+		users, err := queryUsersAsOf(db, tableInPointOfTime, onlyNewerThan, offset, maxResults)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "%+v", err)
 			return
 		}
-		defer rows.Close()
-
-		users := []User{}
-		for rows.Next() {
-			var user User
-			if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.UpdatedAt, &user.CreatedAt); err != nil {
+		pageCount := len(users)
+		lastModified := maxUpdatedAt(users, tableInPointOfTime)
+
+		// A DELETE is invisible to the FOR SYSTEM_TIME query above: MariaDB's system-versioned
+		// history still holds the old row, but it no longer satisfies "updated_at > onlyNewerThan"
+		// once it's gone. Union in tombstones recorded by clearHandler/deleteHandler so a client
+		// incrementally syncing sees removals instead of silently losing track of the row.
+		if !onlyNewerThan.IsZero() {
+			tombstones, err := queryTombstonesAsOf(db, onlyNewerThan, maxResults-pageCount)
+			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprintf(w, "%+v", err)
 				return
 			}
-			users = append(users, user)
+			users = append(users, tombstones...)
 		}
-		if err = rows.Err(); err != nil {
+
+		validUntil := time.Now().AddDate(0, 0, 1)
+		body, err := json.Marshal(buildListResponse(users, pageCount, tableInPointOfTime, onlyNewerThan, offset, maxResults, validUntil))
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "%+v", err)
 			return
 		}
+		listCache.Add(cacheKey, listCacheEntry{body: body, lastModified: lastModified, validUntil: validUntil})
+
+		writeListResponse(w, r, etag, lastModified, validUntil, body)
+	}
+}
+
+// queryUsersAsOf runs the same FOR SYSTEM_TIME AS OF query used by listHandler and syncHandler so
+// both endpoints see an identical, deterministic view of the users table for a given timestamp.
+func queryUsersAsOf(db *sql.DB, tableInPointOfTime, onlyNewerThan time.Time, offset, maxResults int) ([]User, error) {
+	var rows *sql.Rows
+	var err error
+	if onlyNewerThan.IsZero() {
+		rows, err = db.Query(
+			"SELECT * FROM users FOR SYSTEM_TIME AS OF TIMESTAMP ? ORDER BY name LIMIT ? OFFSET ?",
+			tableInPointOfTime.Format(time.DateTime),
+			maxResults,
+			offset,
+		)
+	} else {
+		rows, err = db.Query(
+			"SELECT * FROM users FOR SYSTEM_TIME AS OF TIMESTAMP ? WHERE updated_at > ? ORDER BY name LIMIT ? OFFSET ?",
+			tableInPointOfTime.Format(time.DateTime),
+			onlyNewerThan,
+			maxResults,
+			offset,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.UpdatedAt, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// queryTombstonesAsOf returns up to limit deletions recorded in user_tombstones since
+// onlyNewerThan, oldest first, so listHandler can union them into a sync response. A zero
+// onlyNewerThan (a client fetching the first page, not incrementally syncing) has no prior state
+// to reconcile against, so no tombstones are relevant.
+func queryTombstonesAsOf(db *sql.DB, onlyNewerThan time.Time, limit int) ([]User, error) {
+	if onlyNewerThan.IsZero() || limit <= 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(
+		"SELECT id, deleted_at FROM user_tombstones WHERE deleted_at > ? ORDER BY deleted_at LIMIT ?",
+		onlyNewerThan,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tombstones []User
+	for rows.Next() {
+		var id, deletedAt string
+		if err := rows.Scan(&id, &deletedAt); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, User{ID: id, UpdatedAt: deletedAt, Deleted: true})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// buildListResponse applies the next-page-token-vs-sync-token rule shared by /list and /sync: a
+// full page gets a next page token, anything shorter gets a sync token. pageCount is the number of
+// rows the query actually returned for the live page, i.e. users excluding any unioned tombstones,
+// since tombstones don't count against the page being "full".
+func buildListResponse(users []User, pageCount int, tableInPointOfTime, onlyNewerThan time.Time, offset, maxResults int, validUntil time.Time) ListResponse {
+	var nextPageToken *NextPageToken
+	var syncToken *SyncToken
+	if pageCount == 0 || pageCount < maxResults {
+		syncToken = &SyncToken{Timestamp: tableInPointOfTime, ValidUntil: validUntil}
+	} else {
+		nextPageToken = &NextPageToken{
+			Timestamp:    tableInPointOfTime,
+			UpdatedAfter: onlyNewerThan,
+			Offset:       offset + pageCount,
+			ValidUntil:   validUntil,
+		}
+	}
+	return ListResponse{Users: users, NextPageToken: nextPageToken, SyncToken: syncToken}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding response: %v", err)
+	}
+}
+
+// listCacheBucket is the granularity tableInPointOfTime is truncated to before being folded into
+// a cache key: requests for a "first page" landing in the same window become a single cache entry.
+const listCacheBucket = 5 * time.Second
+
+// listCacheEntry holds everything a cache hit needs to reproduce the exact response (and its
+// conditional-request headers) a cache miss would have produced for the same key.
+type listCacheEntry struct {
+	body         []byte
+	lastModified time.Time
+	validUntil   time.Time
+}
+
+var (
+	// listCache warms listHandler's response for popular initial requests: since FOR SYSTEM_TIME
+	// AS OF TIMESTAMP is deterministic for a fixed timestamp, a cached ListResponse stays correct
+	// until the token inside it expires.
+	listCache, _ = lru.New[string, listCacheEntry](1024)
+	// listCacheGeneration is folded into the cache key and bumped by insertHandler/deleteHandler/
+	// clearHandler, so a write immediately invalidates every cached page rather than waiting for
+	// listCacheBucket to roll over.
+	listCacheGeneration atomic.Uint64
+	listCacheHits       atomic.Uint64
+	listCacheMisses     atomic.Uint64
+)
 
-		if len(users) == 0 || len(users) < maxResults {
-			syncToken = &SyncToken{Timestamp: tableInPointOfTime, ValidUntil: time.Now().AddDate(0, 0, 1)}
-		} else {
-			nextPageToken = &NextPageToken{
-				Timestamp:    tableInPointOfTime,
-				UpdatedAfter: onlyNewerThan,
-				Offset:       offset + len(users),
-				ValidUntil:   time.Now().AddDate(0, 0, 1),
+func listCacheKey(tableInPointOfTime, onlyNewerThan time.Time, offset, maxResults int) string {
+	return fmt.Sprintf("%d:%d:%d:%d:%d",
+		tableInPointOfTime.Unix(),
+		onlyNewerThan.Unix(),
+		offset,
+		maxResults,
+		listCacheGeneration.Load(),
+	)
+}
+
+// listETag computes a strong ETag for a /list request. The FOR SYSTEM_TIME query parameters make
+// the live page deterministic, but an incrementally syncing request (onlyNewerThan set) also
+// unions in tombstones that are genuinely live, unbounded by tableInPointOfTime: a write landing
+// between two otherwise-identical requests changes the response without changing those
+// parameters. Folding in listCacheGeneration, which every write already bumps, makes the ETag
+// change whenever the response could have, so a stale If-None-Match can't mask a missed deletion.
+func listETag(tableInPointOfTime, onlyNewerThan time.Time, offset, maxResults int) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d|%d|%d|%d|%d", tableInPointOfTime.Unix(), onlyNewerThan.Unix(), offset, maxResults, listCacheGeneration.Load()))
+	return fmt.Sprintf(`"%x"`, sum)
+}
+
+// maxUpdatedAt returns the latest updated_at among users, or fallback if users is empty or none of
+// its timestamps parse as time.DateTime (fractional seconds after the seconds field are accepted).
+func maxUpdatedAt(users []User, fallback time.Time) time.Time {
+	var lastModified time.Time
+	found := false
+	for _, u := range users {
+		t, err := time.Parse(time.DateTime, u.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		if !found || t.After(lastModified) {
+			lastModified = t
+			found = true
+		}
+	}
+	if !found {
+		return fallback
+	}
+	return lastModified
+}
+
+// writeListResponse applies conditional-request semantics shared by every /list response,
+// including cache hits: it always sets ETag/Last-Modified/Cache-Control, then answers
+// If-None-Match or If-Modified-Since with a bodyless 304 when the client's copy is still current.
+func writeListResponse(w http.ResponseWriter, r *http.Request, etag string, lastModified, validUntil time.Time, body []byte) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	maxAge := int(time.Until(validUntil).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func metricsHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"list_cache_hits":   listCacheHits.Load(),
+			"list_cache_misses": listCacheMisses.Load(),
+		})
+	}
+}
+
+const (
+	// syncDefaultTimeout is how long a /sync request blocks when the client omits "timeout".
+	syncDefaultTimeout = 30 * time.Second
+	// syncMaxTimeout caps how long a /sync request may block regardless of the requested timeout.
+	syncMaxTimeout = 30 * time.Second
+	// maxSyncSubscribers bounds the number of concurrently blocked /sync requests.
+	maxSyncSubscribers = 1000
+)
+
+// syncHandler is a long-poll variant of /list modeled on MSC3575-style sliding sync: a client
+// supplies a sync_token and is held open, up to "timeout" seconds, until a change newer than the
+// token appears or the timeout elapses. The response has the same ListResponse shape as /list so
+// existing clients can move over incrementally.
+func syncHandler(db *sql.DB, notifier *changeNotifier) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("sync_token")
+		if v == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "sync_token is required")
+			return
+		}
+		syncToken := &SyncToken{}
+		if err := syncToken.UnmarshalJSON([]byte(v)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		if syncToken.ValidUntil.Before(time.Now()) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "sync token expired")
+			return
+		}
+		onlyNewerThan := syncToken.Timestamp
+
+		timeout := syncDefaultTimeout
+		if tv := r.URL.Query().Get("timeout"); tv != "" {
+			if secs, err := strconv.Atoi(tv); err == nil && secs >= 0 {
+				timeout = time.Duration(secs) * time.Second
 			}
 		}
+		if timeout > syncMaxTimeout {
+			timeout = syncMaxTimeout
+		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(ListResponse{
-			Users:         users,
-			NextPageToken: nextPageToken,
-			SyncToken:     syncToken,
-		}); err != nil {
-			log.Printf("error encoding response: %v", err)
+		maxResults := 10
+		if v := r.URL.Query().Get("max_results"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				maxResults = n
+			}
+		}
+
+		id, changed, ok := notifier.subscribe(maxSyncSubscribers)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "too many active /sync subscribers")
+			return
+		}
+		defer notifier.unsubscribe(id)
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		for {
+			tableInPointOfTime := time.Now().UTC()
+			users, err := queryUsersAsOf(db, tableInPointOfTime, onlyNewerThan, 0, maxResults)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "%+v", err)
+				return
+			}
+			pageCount := len(users)
+
+			// Union in tombstones for the same reason listHandler does: a DELETE or /clear is
+			// invisible to the FOR SYSTEM_TIME query above, and /sync is the endpoint incrementally
+			// syncing clients rely on to learn about removals. insertHandler/deleteHandler/
+			// clearHandler all call notifier.notify(), so a tombstone written by /delete or /clear
+			// wakes this loop the same way a new row does.
+			tombstones, err := queryTombstonesAsOf(db, onlyNewerThan, maxResults-pageCount)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "%+v", err)
+				return
+			}
+			users = append(users, tombstones...)
+
+			if len(users) > 0 {
+				writeJSON(w, http.StatusOK, buildListResponse(users, pageCount, tableInPointOfTime, onlyNewerThan, 0, maxResults, time.Now().AddDate(0, 0, 1)))
+				return
+			}
+
+			select {
+			case <-changed:
+				continue
+			case <-timer.C:
+				writeJSON(w, http.StatusOK, buildListResponse(users, pageCount, tableInPointOfTime, onlyNewerThan, 0, maxResults, time.Now().AddDate(0, 0, 1)))
+				return
+			case <-r.Context().Done():
+				return
+			}
 		}
 	}
 }
 
-func insertHandler(db *sql.DB) func(w http.ResponseWriter, r *http.Request) {
+// changeNotifier fans out a wake-up to every /sync subscriber whenever insertHandler,
+// deleteHandler or clearHandler mutates the users table, so long-polling clients don't have to be woken by polling
+// on a fixed interval.
+type changeNotifier struct {
+	mu          sync.Mutex
+	nextID      int
+	subscribers map[int]chan struct{}
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{subscribers: make(map[int]chan struct{})}
+}
+
+// subscribe registers a new subscriber, unless doing so would exceed limit, in which case ok is
+// false and the other return values are zero. The limit check and the registration happen under
+// the same lock so concurrent callers can't all pass the check before any of them registers.
+func (n *changeNotifier) subscribe(limit int) (id int, changed chan struct{}, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.subscribers) >= limit {
+		return 0, nil, false
+	}
+	id = n.nextID
+	n.nextID++
+	changed = make(chan struct{}, 1)
+	n.subscribers[id] = changed
+	return id, changed, true
+}
+
+func (n *changeNotifier) unsubscribe(id int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.subscribers, id)
+}
+
+func (n *changeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, changed := range n.subscribers {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func insertHandler(db *sql.DB, notifier *changeNotifier) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var user User
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -225,18 +583,72 @@ func insertHandler(db *sql.DB) func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "%+v", err)
 			return
 		}
+		listCacheGeneration.Add(1)
+		notifier.notify()
+
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+func clearHandler(db *sql.DB, notifier *changeNotifier) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx, err := db.Begin()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		defer tx.Rollback()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(user); err != nil {
-			log.Printf("error encoding response: %v", err)
+		// Tombstone every row before it's gone so incrementally syncing clients can still see
+		// the removal; see queryTombstonesAsOf.
+		if _, err := tx.Exec("INSERT INTO user_tombstones (id, deleted_at) SELECT id, ? FROM users", time.Now().UTC()); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
 		}
+		res, err := tx.Exec("DELETE FROM users")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		affectedRows, err := res.RowsAffected()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		listCacheGeneration.Add(1)
+		notifier.notify()
+
+		writeJSON(w, http.StatusOK, map[string]any{"affected_rows": affectedRows})
 	}
 }
 
-func clearHandler(db *sql.DB) func(w http.ResponseWriter, r *http.Request) {
+func deleteHandler(db *sql.DB, notifier *changeNotifier) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		res, err := db.Exec("DELETE FROM users")
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "id is required")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "%+v", err)
@@ -248,11 +660,39 @@ func clearHandler(db *sql.DB) func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "%+v", err)
 			return
 		}
+		if affectedRows > 0 {
+			if _, err := tx.Exec("INSERT INTO user_tombstones (id, deleted_at) VALUES (?, ?)", id, time.Now().UTC()); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "%+v", err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%+v", err)
+			return
+		}
+		listCacheGeneration.Add(1)
+		notifier.notify()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(map[string]any{"affected_rows": affectedRows}); err != nil {
-			log.Printf("error encoding response: %v", err)
+		writeJSON(w, http.StatusOK, map[string]any{"affected_rows": affectedRows})
+	}
+}
+
+// tombstoneRetention mirrors the system-versioning retention window the rest of this handler
+// assumes (see the listHandler doc comment): once a point in time falls out of that window,
+// "valid until" tokens referencing it are already rejected as expired, so tombstones older than
+// the window can't be reconciled against by any client and are safe to purge.
+const tombstoneRetention = 24 * time.Hour
+
+// purgeTombstones periodically deletes user_tombstones rows older than tombstoneRetention, so
+// storage for deletions stays bounded the same way MariaDB bounds system-versioned history.
+func purgeTombstones(db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := db.Exec("DELETE FROM user_tombstones WHERE deleted_at < ?", time.Now().Add(-tombstoneRetention)); err != nil {
+			log.Printf("error purging tombstones: %v", err)
 		}
 	}
 }
@@ -263,6 +703,9 @@ type User struct {
 	Email     string `db:"email" json:"email"`
 	CreatedAt string `db:"created_at" json:"created_at"`
 	UpdatedAt string `db:"updated_at" json:"updated_at"`
+	// Deleted marks an entry as a tombstone rather than a live row: only ID and UpdatedAt (set to
+	// the deletion time) are populated. See queryTombstonesAsOf.
+	Deleted bool `db:"-" json:"deleted,omitempty"`
 }
 
 type ListResponse struct {
@@ -298,17 +741,11 @@ func (t *NextPageToken) MarshalJSON() ([]byte, error) {
 		return nil, err
 	}
 
-	size := base64.StdEncoding.EncodedLen(len(src)) + 2
-	buf := make([]byte, size)
-	buf[0] = '"'
-	buf[size-1] = '"'
-	base64.StdEncoding.Encode(buf[1:], src)
-	return buf, nil
+	return encodeToken(src)
 }
 
 func (t *NextPageToken) UnmarshalJSON(data []byte) error {
-	buf := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
-	n, err := base64.StdEncoding.Decode(buf, data)
+	src, err := decodeToken(data)
 	if err != nil {
 		return err
 	}
@@ -319,7 +756,7 @@ func (t *NextPageToken) UnmarshalJSON(data []byte) error {
 		UpdatedAfter *int64 `json:"u"`
 		ValidUntil   int64  `json:"v"`
 	}
-	if err := json.Unmarshal(buf[:n], &v); err != nil {
+	if err := json.Unmarshal(src, &v); err != nil {
 		return err
 	}
 	t.Timestamp = time.Unix(v.Timestamp, 0)
@@ -351,17 +788,11 @@ func (t *SyncToken) MarshalJSON() ([]byte, error) {
 		return nil, err
 	}
 
-	size := base64.StdEncoding.EncodedLen(len(src)) + 2
-	buf := make([]byte, size)
-	buf[0] = '"'
-	buf[size-1] = '"'
-	base64.StdEncoding.Encode(buf[1:], src)
-	return buf, nil
+	return encodeToken(src)
 }
 
 func (t *SyncToken) UnmarshalJSON(data []byte) error {
-	buf := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
-	n, err := base64.StdEncoding.Decode(buf, data)
+	src, err := decodeToken(data)
 	if err != nil {
 		return err
 	}
@@ -370,10 +801,143 @@ func (t *SyncToken) UnmarshalJSON(data []byte) error {
 		Timestamp  int64 `json:"t"`
 		ValidUntil int64 `json:"v"`
 	}
-	if err := json.Unmarshal(buf[:n], &v); err != nil {
+	if err := json.Unmarshal(src, &v); err != nil {
 		return err
 	}
 	t.Timestamp = time.Unix(v.Timestamp, 0)
 	t.ValidUntil = time.Unix(v.ValidUntil, 0)
 	return nil
 }
+
+// tokenKeys holds the AES-256 keys used to seal next-page and sync tokens, in rotation order: the
+// last entry is used to seal new tokens, while all entries remain valid for opening tokens sealed
+// by an older key. It is loaded once from TOKEN_ENCRYPTION_KEY at startup.
+var tokenKeys = loadTokenKeys()
+
+// loadTokenKeys parses TOKEN_ENCRYPTION_KEY as a comma-separated list of base64-encoded 32-byte
+// AES-256 keys, oldest first. An unset (or empty) env var disables encryption, which falls back
+// to the unsigned token format (see encryptToken) and is only suitable for local development.
+func loadTokenKeys() [][]byte {
+	raw := os.Getenv("TOKEN_ENCRYPTION_KEY")
+	if raw == "" {
+		return nil
+	}
+
+	parts := strings.Split(raw, ",")
+	keys := make([][]byte, 0, len(parts))
+	for _, p := range parts {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(p))
+		if err != nil {
+			panic(fmt.Sprintf("TOKEN_ENCRYPTION_KEY: invalid base64 key: %v", err))
+		}
+		if len(key) != 32 {
+			panic("TOKEN_ENCRYPTION_KEY: each key must decode to 32 bytes for AES-256")
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// encodeToken seals src (if tokenKeys is configured) and base64-encodes the result as a quoted
+// JSON string, matching the wire format the token types have always used.
+func encodeToken(src []byte) ([]byte, error) {
+	sealed, err := encryptToken(src)
+	if err != nil {
+		return nil, err
+	}
+
+	size := base64.StdEncoding.EncodedLen(len(sealed)) + 2
+	buf := make([]byte, size)
+	buf[0] = '"'
+	buf[size-1] = '"'
+	base64.StdEncoding.Encode(buf[1:], sealed)
+	return buf, nil
+}
+
+// decodeToken reverses encodeToken: base64-decode the quoted JSON string, then open the sealed
+// payload, rejecting anything truncated, tampered with, or sealed under an unknown key. With no
+// keys configured, an unsigned payload is returned as is, without any integrity check.
+func decodeToken(data []byte) ([]byte, error) {
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(buf, data)
+	if err != nil {
+		return nil, fmt.Errorf("token: invalid base64: %w", err)
+	}
+	return decryptToken(buf[:n])
+}
+
+// encryptToken seals src under the most recently configured key, prefixed with a 1-byte key ID
+// (1-indexed; 0 means "unsigned") and a random 12-byte GCM nonce. With no configured keys it
+// falls back to an unsigned format: a 0 key-ID byte followed by src in plain text. Tokens from
+// earlier versions of this handler, which had no key-ID byte, are not accepted by decryptToken.
+func encryptToken(src []byte) ([]byte, error) {
+	if len(tokenKeys) == 0 {
+		out := make([]byte, 0, len(src)+1)
+		out = append(out, 0)
+		out = append(out, src...)
+		return out, nil
+	}
+
+	keyID := len(tokenKeys) - 1
+	gcm, err := newGCM(tokenKeys[keyID])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 1+len(nonce)+len(src)+gcm.Overhead())
+	out = append(out, byte(keyID+1))
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, src, nil), nil
+}
+
+// decryptToken opens a payload produced by encryptToken, selecting the key by the leading ID
+// byte so that tokens sealed before a key rotation keep working as long as the old key is still
+// present in TOKEN_ENCRYPTION_KEY. The ID is the key's 1-based position in that list, so removing
+// an earlier key shifts the IDs of the keys after it and invalidates tokens sealed under them.
+func decryptToken(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("token: empty payload")
+	}
+
+	keyID, payload := data[0], data[1:]
+	if keyID == 0 {
+		if len(tokenKeys) != 0 {
+			return nil, fmt.Errorf("token: unsigned token rejected, server requires signed tokens")
+		}
+		return payload, nil
+	}
+
+	idx := int(keyID) - 1
+	if idx < 0 || idx >= len(tokenKeys) {
+		return nil, fmt.Errorf("token: unknown key id %d", keyID)
+	}
+
+	gcm, err := newGCM(tokenKeys[idx])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) < gcm.NonceSize() {
+		return nil, fmt.Errorf("token: truncated payload")
+	}
+
+	nonce, ciphertext := payload[:gcm.NonceSize()], payload[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("token: tampered or invalid payload: %w", err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
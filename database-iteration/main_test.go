@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListETagChangesWithGeneration(t *testing.T) {
+	original := listCacheGeneration.Load()
+	defer listCacheGeneration.Store(original)
+	listCacheGeneration.Store(0)
+
+	now := time.Unix(1700000000, 0)
+	etag1 := listETag(now, time.Time{}, 0, 10)
+	listCacheGeneration.Add(1)
+	etag2 := listETag(now, time.Time{}, 0, 10)
+
+	if etag1 == etag2 {
+		t.Fatal("expected the ETag to change once a write bumps listCacheGeneration")
+	}
+}
+
+func TestWriteListResponseIfNoneMatch(t *testing.T) {
+	etag := `"abc"`
+	body := []byte(`{"users":[]}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	writeListResponse(rec, req, etag, time.Unix(1700000000, 0), time.Now().Add(time.Hour), body)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected no body on 304, got %q", rec.Body.String())
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Fatalf("got ETag %q, want %q", got, etag)
+	}
+}
+
+func TestWriteListResponseIfModifiedSince(t *testing.T) {
+	etag := `"abc"`
+	lastModified := time.Unix(1700000000, 0)
+	body := []byte(`{"users":[]}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.Header.Set("If-Modified-Since", lastModified.UTC().Format(http.TimeFormat))
+	rec := httptest.NewRecorder()
+	writeListResponse(rec, req, etag, lastModified, time.Now().Add(time.Hour), body)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestWriteListResponseMalformedIfModifiedSince(t *testing.T) {
+	etag := `"abc"`
+	body := []byte(`{"users":[]}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.Header.Set("If-Modified-Since", "not-a-date")
+	rec := httptest.NewRecorder()
+	writeListResponse(rec, req, etag, time.Unix(1700000000, 0), time.Now().Add(time.Hour), body)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("a malformed If-Modified-Since should be ignored, got status %d", rec.Code)
+	}
+	if rec.Body.String() != string(body) {
+		t.Fatalf("got body %q, want %q", rec.Body.String(), body)
+	}
+}
+
+func TestWriteListResponseStaleETagAfterSyncTokenWrite(t *testing.T) {
+	// A /list?sync_token=... request must not be served a 304 from an If-None-Match computed
+	// before a write (e.g. a /delete tombstone) changed what the same query parameters return.
+	original := listCacheGeneration.Load()
+	defer listCacheGeneration.Store(original)
+	listCacheGeneration.Store(0)
+
+	tableInPointOfTime := time.Unix(1700000000, 0)
+	onlyNewerThan := time.Unix(1699999000, 0)
+	staleETag := listETag(tableInPointOfTime, onlyNewerThan, 0, 10)
+
+	listCacheGeneration.Add(1) // a write landed, e.g. a /delete tombstone
+	freshETag := listETag(tableInPointOfTime, onlyNewerThan, 0, 10)
+	freshBody := []byte(`{"users":[{"id":"1","deleted":true}]}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/list?sync_token=x", nil)
+	req.Header.Set("If-None-Match", staleETag)
+	rec := httptest.NewRecorder()
+	writeListResponse(rec, req, freshETag, time.Now(), time.Now().Add(time.Hour), freshBody)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("a stale If-None-Match must not suppress a response from a newer generation, got status %d", rec.Code)
+	}
+	if rec.Body.String() != string(freshBody) {
+		t.Fatalf("got body %q, want %q", rec.Body.String(), freshBody)
+	}
+}
+
+func TestMaxUpdatedAt(t *testing.T) {
+	fallback := time.Unix(1700000000, 0)
+	users := []User{
+		{UpdatedAt: "2023-11-14 22:13:20"},
+		{UpdatedAt: "2023-11-15 22:13:20"},
+		{UpdatedAt: "not-a-timestamp"},
+	}
+
+	got := maxUpdatedAt(users, fallback)
+	want := time.Date(2023, time.November, 15, 22, 13, 20, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMaxUpdatedAtEmptyFallsBack(t *testing.T) {
+	fallback := time.Unix(1700000000, 0)
+	if got := maxUpdatedAt(nil, fallback); !got.Equal(fallback) {
+		t.Fatalf("got %v, want fallback %v", got, fallback)
+	}
+}
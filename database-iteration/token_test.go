@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// withTokenKeys swaps the package-level tokenKeys for the duration of a test, restoring the
+// original afterwards.
+func withTokenKeys(t *testing.T, keys [][]byte) {
+	t.Helper()
+	original := tokenKeys
+	tokenKeys = keys
+	t.Cleanup(func() { tokenKeys = original })
+}
+
+func testKey(seed byte) []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = seed
+	}
+	return key
+}
+
+// unquoteToken strips the JSON string quotes MarshalJSON wraps its output in. Real callers never
+// see those quotes: the token is marshaled as a field of ListResponse, and a client extracts the
+// string value (e.g. via a JSON decoder) before echoing it back as an unquoted query parameter, as
+// every UnmarshalJSON call site in main.go assumes.
+func unquoteToken(data []byte) []byte {
+	return data[1 : len(data)-1]
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	withTokenKeys(t, [][]byte{testKey(1)})
+
+	want := &SyncToken{Timestamp: time.Unix(1700000000, 0), ValidUntil: time.Unix(1700086400, 0)}
+	data, err := want.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	got := &SyncToken{}
+	if err := got.UnmarshalJSON(unquoteToken(data)); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	if !got.Timestamp.Equal(want.Timestamp) || !got.ValidUntil.Equal(want.ValidUntil) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestTokenUnsignedFallback(t *testing.T) {
+	withTokenKeys(t, nil)
+
+	want := &NextPageToken{Timestamp: time.Unix(1700000000, 0), Offset: 5, ValidUntil: time.Unix(1700086400, 0)}
+	data, err := want.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	got := &NextPageToken{}
+	if err := got.UnmarshalJSON(unquoteToken(data)); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	if got.Offset != want.Offset || !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestTokenTampering(t *testing.T) {
+	withTokenKeys(t, [][]byte{testKey(1)})
+
+	token := &SyncToken{Timestamp: time.Unix(1700000000, 0), ValidUntil: time.Unix(1700086400, 0)}
+	data, err := token.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	tampered := append([]byte(nil), unquoteToken(data)...)
+	mid := len(tampered) / 2
+	if tampered[mid] == 'A' {
+		tampered[mid] = 'B'
+	} else {
+		tampered[mid] = 'A'
+	}
+
+	if err := (&SyncToken{}).UnmarshalJSON(tampered); err == nil {
+		t.Fatal("expected a tampered token to fail to decode")
+	}
+}
+
+func TestTokenKeyRotationReplay(t *testing.T) {
+	keyA, keyB := testKey(1), testKey(2)
+
+	withTokenKeys(t, [][]byte{keyA})
+	old := &SyncToken{Timestamp: time.Unix(1700000000, 0), ValidUntil: time.Unix(1700086400, 0)}
+	oldData, err := old.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	// Rotate: keyB becomes the signing key, keyA stays around to open tokens sealed before.
+	tokenKeys = [][]byte{keyA, keyB}
+
+	replayed := &SyncToken{}
+	if err := replayed.UnmarshalJSON(unquoteToken(oldData)); err != nil {
+		t.Fatalf("token sealed under the retired key should still decode after rotation: %v", err)
+	}
+	if !replayed.Timestamp.Equal(old.Timestamp) {
+		t.Fatalf("got %+v, want %+v", replayed, old)
+	}
+
+	fresh := &SyncToken{Timestamp: time.Unix(1700100000, 0), ValidUntil: time.Unix(1700186400, 0)}
+	freshData, err := fresh.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+	gotFresh := &SyncToken{}
+	if err := gotFresh.UnmarshalJSON(unquoteToken(freshData)); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	if !gotFresh.Timestamp.Equal(fresh.Timestamp) {
+		t.Fatalf("got %+v, want %+v", gotFresh, fresh)
+	}
+}
+
+func TestTokenRejectedAfterKeyDropped(t *testing.T) {
+	withTokenKeys(t, [][]byte{testKey(1)})
+	token := &SyncToken{Timestamp: time.Unix(1700000000, 0), ValidUntil: time.Unix(1700086400, 0)}
+	data, err := token.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	// A rotation that drops the retiring key entirely must reject tokens sealed under it.
+	tokenKeys = [][]byte{testKey(2)}
+
+	if err := (&SyncToken{}).UnmarshalJSON(unquoteToken(data)); err == nil {
+		t.Fatal("expected a token sealed under a dropped key to be rejected")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	withTokenKeys(t, [][]byte{testKey(1)})
+
+	expired := &SyncToken{Timestamp: time.Unix(1700000000, 0), ValidUntil: time.Unix(1700000001, 0)}
+	data, err := expired.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	got := &SyncToken{}
+	if err := got.UnmarshalJSON(unquoteToken(data)); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	// Decoding an expired token must succeed (that's decryptToken's job); it's up to callers like
+	// listHandler to compare ValidUntil against time.Now() and reject it.
+	if !got.ValidUntil.Before(time.Now()) {
+		t.Fatal("decoded ValidUntil should still reflect the expired timestamp")
+	}
+}
+
+func TestDecryptTokenRejectsMalformedPayloads(t *testing.T) {
+	withTokenKeys(t, [][]byte{testKey(1)})
+
+	if _, err := decryptToken(nil); err == nil {
+		t.Fatal("expected an empty payload to be rejected")
+	}
+	if _, err := decryptToken([]byte{1}); err == nil {
+		t.Fatal("expected a payload truncated before the nonce to be rejected")
+	}
+	if _, err := decryptToken([]byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatal("expected an unknown key id to be rejected")
+	}
+}